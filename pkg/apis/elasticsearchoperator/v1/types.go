@@ -0,0 +1,185 @@
+/*
+Copyright (c) 2017, UPMC Enterprises
+All rights reserved.
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+    * Redistributions of source code must retain the above copyright
+      notice, this list of conditions and the following disclaimer.
+    * Redistributions in binary form must reproduce the above copyright
+      notice, this list of conditions and the following disclaimer in the
+      documentation and/or other materials provided with the distribution.
+    * Neither the name UPMC Enterprises nor the
+      names of its contributors may be used to endorse or promote products
+      derived from this software without specific prior written permission.
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND
+ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED
+WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL UPMC ENTERPRISES BE LIABLE FOR ANY
+DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+(INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR SERVICES;
+LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND
+ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
+(INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE OF THIS
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ElasticsearchCluster represents an Elasticsearch cluster managed by the operator.
+type ElasticsearchCluster struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ClusterSpec   `json:"spec"`
+	Status ClusterStatus `json:"status,omitempty"`
+}
+
+// ElasticsearchClusterList is a list of ElasticsearchCluster resources.
+type ElasticsearchClusterList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []ElasticsearchCluster `json:"items"`
+}
+
+// ClusterSpec is the spec for an ElasticsearchCluster resource.
+type ClusterSpec struct {
+	ServiceAccountName string `json:"service-account,omitempty"`
+
+	ClientNodeReplicas int32 `json:"client-node-replicas"`
+	MasterNodeReplicas int32 `json:"master-node-replicas"`
+	DataNodeReplicas   int32 `json:"data-node-replicas"`
+
+	// Certs controls how the operator issues and rotates the cluster's TLS material.
+	Certs CertsSpec `json:"certs,omitempty"`
+
+	// TLS overrides the key algorithm, size, SANs, subject and validity the
+	// operator uses when minting the CA and each component's leaf cert.
+	TLS TLSSpec `json:"tls,omitempty"`
+}
+
+// KeyAlgorithm selects the public key algorithm used for a generated keypair.
+type KeyAlgorithm string
+
+const (
+	// KeyAlgorithmRSA generates an RSA keypair. This is the default.
+	KeyAlgorithmRSA KeyAlgorithm = "RSA"
+	// KeyAlgorithmECDSA generates an ECDSA keypair.
+	KeyAlgorithmECDSA KeyAlgorithm = "ECDSA"
+)
+
+// ECDSACurve selects the curve used for an ECDSA keypair.
+type ECDSACurve string
+
+const (
+	// ECDSACurveP256 selects the P-256 curve. This is the default for ECDSA keys.
+	ECDSACurveP256 ECDSACurve = "P-256"
+	// ECDSACurveP384 selects the P-384 curve.
+	ECDSACurveP384 ECDSACurve = "P-384"
+)
+
+// SubjectSpec overrides the X.509 subject fields the operator would
+// otherwise derive automatically for a generated cert.
+type SubjectSpec struct {
+	Organization       string `json:"organization,omitempty"`
+	OrganizationalUnit string `json:"organizationalUnit,omitempty"`
+	Locality           string `json:"locality,omitempty"`
+	Province           string `json:"province,omitempty"`
+	Country            string `json:"country,omitempty"`
+	CommonName         string `json:"commonName,omitempty"`
+}
+
+// KeySpec configures the key algorithm/size, SANs, subject and validity
+// used for one component's certificate (or the CA).
+type KeySpec struct {
+	// Algorithm is "RSA" or "ECDSA". Defaults to "RSA".
+	Algorithm KeyAlgorithm `json:"algorithm,omitempty"`
+	// RSAKeySize is the RSA modulus size in bits when Algorithm is "RSA":
+	// 2048, 3072 or 4096. Defaults to 2048.
+	RSAKeySize int `json:"rsaKeySize,omitempty"`
+	// ECDSACurve is the curve to use when Algorithm is "ECDSA". Defaults to "P-256".
+	ECDSACurve ECDSACurve `json:"ecdsaCurve,omitempty"`
+
+	// ExtraDNSNames are additional DNS SANs appended to the hostnames the
+	// operator derives automatically from the cluster name and namespace.
+	ExtraDNSNames []string `json:"extraDNSNames,omitempty"`
+	// ExtraIPAddresses are IP SANs for endpoints the operator can't derive
+	// on its own, such as an external LoadBalancer or ingress hostname.
+	ExtraIPAddresses []string `json:"extraIPAddresses,omitempty"`
+
+	// Subject overrides the O/OU/L/ST/C/CN fields the operator would
+	// otherwise set for this component.
+	Subject *SubjectSpec `json:"subject,omitempty"`
+
+	// Validity overrides how long this component's cert stays valid.
+	// Defaults to CertsSpec.CAValidity for the CA and CertsSpec.CertValidity
+	// for a leaf, or 8760h (1 year) when that is unset too.
+	Validity metav1.Duration `json:"validity,omitempty"`
+}
+
+// TLSSpec lets users override the key algorithm, size, SANs, subject and
+// validity the operator uses for the CA and for each component cert.
+type TLSSpec struct {
+	CA      KeySpec `json:"ca,omitempty"`
+	Node    KeySpec `json:"node,omitempty"`
+	Sgadmin KeySpec `json:"sgadmin,omitempty"`
+	Kibana  KeySpec `json:"kibana,omitempty"`
+	Cerebro KeySpec `json:"cerebro,omitempty"`
+}
+
+// CertsSpec controls how the operator issues and rotates TLS material for
+// the cluster's CA and per-component leaf certs (node, sgadmin, kibana,
+// cerebro).
+type CertsSpec struct {
+	// CAValidity is how long the generated root CA stays valid. Defaults to 8760h (1 year).
+	CAValidity metav1.Duration `json:"caValidity,omitempty"`
+	// CertValidity is how long a generated leaf cert stays valid. Defaults to 8760h (1 year).
+	CertValidity metav1.Duration `json:"certValidity,omitempty"`
+	// RenewThreshold is the fraction of a leaf cert's own validity window
+	// (NotBefore to NotAfter) remaining at which the rotation controller
+	// reissues it, e.g. 0.33 renews once about a third of the window is
+	// left. Defaults to 1/3.
+	RenewThreshold float64 `json:"renewThreshold,omitempty"`
+
+	// CAFromSecret references an existing Secret containing a ca.pem/ca-key.pem
+	// keypair. When set, the operator signs node/sgadmin/kibana/cerebro leaves
+	// with this CA instead of generating its own self-signed root. Mutually
+	// exclusive with Issuer.
+	CAFromSecret *CAFromSecretRef `json:"caFromSecret,omitempty"`
+
+	// Issuer references a cert-manager Issuer or ClusterIssuer. When set, the
+	// operator creates a cert-manager Certificate per component and waits for
+	// cert-manager to populate the resulting secrets, skipping the local PKI
+	// pipeline entirely. Mutually exclusive with CAFromSecret.
+	Issuer *CertManagerIssuerRef `json:"issuer,omitempty"`
+}
+
+// CAFromSecretRef points at an existing Secret holding a CA keypair.
+type CAFromSecretRef struct {
+	// Name of the Secret, in the same namespace as the ElasticsearchCluster,
+	// containing ca.pem and ca-key.pem.
+	Name string `json:"name"`
+}
+
+// CertManagerIssuerRef points at a cert-manager Issuer or ClusterIssuer.
+type CertManagerIssuerRef struct {
+	// Name of the Issuer or ClusterIssuer.
+	Name string `json:"name"`
+	// Kind is "Issuer" or "ClusterIssuer". Defaults to "Issuer".
+	Kind string `json:"kind,omitempty"`
+}
+
+// ClusterStatus is the status for an ElasticsearchCluster resource.
+type ClusterStatus struct {
+	Phase string `json:"phase,omitempty"`
+}
+
+// RotateCAAnnotation, when set to "true" on an ElasticsearchCluster, tells
+// the operator to kick off an on-demand root CA rotation: a new CA is
+// appended alongside the current one, every leaf is reissued under it, and
+// the retired CA is only dropped from the truststore once a full rolling
+// restart has completed.
+const RotateCAAnnotation = "elasticsearch-operator.upmc.io/rotate-ca"
@@ -25,225 +25,580 @@ ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT
 package k8sutil
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
+	"math/big"
+	"net"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"time"
+
+	keystore "github.com/pavlo-v-chernykh/keystore-go"
+	pkcs12 "software.sslmate.com/src/go-pkcs12"
 
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"github.com/Sirupsen/logrus"
+	esv1 "github.com/sysbart/elasticsearch-operator/pkg/apis/elasticsearchoperator/v1"
 )
 
-type csr struct {
-	CN    string   `json:"CN,omitempty"`
-	Hosts []string `json:"hosts"`
-	Key   key      `json:"key"`
-	Names []names  `json:"names"`
-}
+const (
+	// keystorePassword is the password used for every PKCS#12/JKS artifact
+	// the operator produces. It is never used to protect the keys at rest
+	// (the secret itself is the trust boundary), only to satisfy formats
+	// that require one.
+	keystorePassword = "changeit"
+
+	caValidity   = 8760 * time.Hour
+	certValidity = 8760 * time.Hour
+	rsaKeySize   = 2048
 
-type caconfig struct {
-	Signing configSigning `json:"signing"`
+	// tlsConfigAnnotation records the TLSSpec passed to CreateCertsSecret,
+	// for auditability.
+	tlsConfigAnnotation = "elasticsearch-operator.upmc.io/tls-config"
+)
+
+// CertGenError is returned by any stage of the in-process PKI pipeline
+// (key generation, signing, or PKCS#8/PKCS#12/JKS encoding). Wrapping the
+// underlying error lets callers use errors.As/errors.Is instead of matching
+// on opaque exec output.
+type CertGenError struct {
+	Stage string
+	Err   error
 }
 
-type configSigning struct {
-	Default configDefault `json:"default"`
+func (e *CertGenError) Error() string {
+	return fmt.Sprintf("k8sutil: generating certs: %s: %v", e.Stage, e.Err)
 }
 
-type configDefault struct {
-	Usages []string `json:"usages"`
-	Expiry string   `json:"expiry"`
+func (e *CertGenError) Unwrap() error { return e.Err }
+
+func newCertGenError(stage string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &CertGenError{Stage: stage, Err: err}
 }
 
-type key struct {
-	Algo string `json:"algo"`
-	Size int    `json:"size"`
+// certSpec describes the identity, SANs and key parameters of a single
+// keypair to be minted.
+type certSpec struct {
+	name       string
+	commonName string
+	hosts      []string
+	ips        []net.IP
+	subject    pkix.Name
+
+	keyAlgorithm esv1.KeyAlgorithm
+	rsaKeySize   int
+	ecdsaCurve   esv1.ECDSACurve
+	validity     time.Duration
 }
 
-type names struct {
-	O  string `json:"O,omitempty"`
-	OU string `json:"OU,omitempty"`
-	L  string `json:"L,omitempty"`
-	C  string `json:"C,omitempty"`
-	ST string `json:"ST,omitempty"`
+// certBundle holds every encoding produced for one component's keypair.
+type certBundle struct {
+	cert        *x509.Certificate
+	certDER     []byte
+	certPEM     []byte
+	key         crypto.Signer
+	keyPEM      []byte
+	keyPKCS8DER []byte
+	keyPKCS8PEM []byte
 }
 
-// GenerateConfig creates the config for certs
-func (k *K8sutil) generateConfig(configDir, certsDir, namespace, clusterName string) error {
-	caConfig := caconfig{
-		Signing: configSigning{
-			Default: configDefault{
-				Usages: []string{
-					"signing",
-					"key encipherment",
-					"server auth",
-					"client auth",
-				},
-				Expiry: "8760h",
-			},
-		},
+// buildCertSpecs returns the CA spec and the per-component leaf specs for a
+// cluster, mirroring the hostnames and subject fields the cfssl configs
+// used to encode as JSON. certs.CAValidity/CertValidity set the default
+// validity for the CA and every leaf respectively (falling back to the
+// package defaults when unset), and tls is applied on top as per-component
+// overrides.
+func buildCertSpecs(namespace, clusterName string, certs esv1.CertsSpec, tls esv1.TLSSpec) (certSpec, []certSpec) {
+	defaultCAValidity := certs.CAValidity.Duration
+	if defaultCAValidity <= 0 {
+		defaultCAValidity = caValidity
+	}
+	defaultCertValidity := certs.CertValidity.Duration
+	if defaultCertValidity <= 0 {
+		defaultCertValidity = certValidity
 	}
 
-	caCSR := csr{
-		Hosts: []string{
+	caSpec := applyKeySpec(certSpec{
+		name: "ca",
+		hosts: []string{
 			"localhost",
 			fmt.Sprintf("elasticsearch-%s", clusterName),
-			fmt.Sprintf("%s.%s", fmt.Sprintf("elasticsearch-%s", clusterName), namespace),
-			fmt.Sprintf("%s.%s.svc.cluster.local", fmt.Sprintf("elasticsearch-%s", clusterName), namespace),
+			fmt.Sprintf("elasticsearch-%s.%s", clusterName, namespace),
+			fmt.Sprintf("elasticsearch-%s.%s.svc.cluster.local", clusterName, namespace),
 		},
-		Key: key{
-			Algo: "rsa",
-			Size: 2048,
+		subject: pkix.Name{
+			Country:            []string{"US"},
+			Locality:           []string{"Pittsburgh"},
+			Organization:       []string{"elasticsearch-operator"},
+			OrganizationalUnit: []string{"k8s"},
+			Province:           []string{"Pennsylvania"},
 		},
-		Names: []names{
-			names{
-				C:  "US",
-				L:  "Pittsburgh",
-				O:  "elasticsearch-operator",
-				OU: "k8s",
-				ST: "Pennsylvania",
+	}, tls.CA, defaultCAValidity)
+
+	components := map[string]esv1.KeySpec{
+		"node":    tls.Node,
+		"sgadmin": tls.Sgadmin,
+		"kibana":  tls.Kibana,
+		"cerebro": tls.Cerebro,
+	}
+
+	var leafSpecs []certSpec
+	for _, name := range []string{"node", "sgadmin", "kibana", "cerebro"} {
+		leafSpecs = append(leafSpecs, applyKeySpec(certSpec{
+			name:       name,
+			commonName: name,
+			hosts: []string{
+				"localhost",
+				fmt.Sprintf("%s-%s", name, clusterName),
+				fmt.Sprintf("%s-%s.%s", name, clusterName, namespace),
+				fmt.Sprintf("%s-%s.%s.svc.cluster.local", name, clusterName, namespace),
 			},
-		},
+			subject: pkix.Name{
+				Organization:       []string{"autogenerated"},
+				OrganizationalUnit: []string{"elasticsearch cluster"},
+				Locality:           []string{"operator"},
+			},
+		}, components[name], defaultCertValidity))
+	}
+
+	return caSpec, leafSpecs
+}
+
+// applyKeySpec layers a user-supplied esv1.KeySpec onto the operator's
+// default certSpec for one component, falling back to defaultValidity and
+// RSA-2048 when ks leaves a field unset.
+func applyKeySpec(base certSpec, ks esv1.KeySpec, defaultValidity time.Duration) certSpec {
+	spec := base
+
+	spec.keyAlgorithm = ks.Algorithm
+	spec.rsaKeySize = ks.RSAKeySize
+	spec.ecdsaCurve = ks.ECDSACurve
+
+	spec.validity = defaultValidity
+	if ks.Validity.Duration > 0 {
+		spec.validity = ks.Validity.Duration
+	}
+
+	if len(ks.ExtraDNSNames) > 0 {
+		spec.hosts = append(append([]string{}, spec.hosts...), ks.ExtraDNSNames...)
+	}
+
+	for _, raw := range ks.ExtraIPAddresses {
+		if ip := net.ParseIP(raw); ip != nil {
+			spec.ips = append(spec.ips, ip)
+		} else {
+			logrus.Warnf("ignoring invalid IP SAN %q for %s", raw, base.name)
+		}
+	}
+
+	if ks.Subject != nil {
+		s := ks.Subject
+		if s.Organization != "" {
+			spec.subject.Organization = []string{s.Organization}
+		}
+		if s.OrganizationalUnit != "" {
+			spec.subject.OrganizationalUnit = []string{s.OrganizationalUnit}
+		}
+		if s.Locality != "" {
+			spec.subject.Locality = []string{s.Locality}
+		}
+		if s.Province != "" {
+			spec.subject.Province = []string{s.Province}
+		}
+		if s.Country != "" {
+			spec.subject.Country = []string{s.Country}
+		}
+		if s.CommonName != "" {
+			spec.commonName = s.CommonName
+		}
 	}
 
-	caConfigJSON, err := json.Marshal(caConfig)
+	return spec
+}
+
+func newSerialNumber() (*big.Int, error) {
+	limit := new(big.Int).Lsh(big.NewInt(1), 128)
+	return rand.Int(rand.Reader, limit)
+}
+
+// generateKey mints a private key matching spec's algorithm/size/curve,
+// defaulting to RSA-2048 when unset.
+func generateKey(spec certSpec) (crypto.Signer, error) {
+	switch spec.keyAlgorithm {
+	case esv1.KeyAlgorithmECDSA:
+		curve, err := ellipticCurve(spec.ecdsaCurve)
+		if err != nil {
+			return nil, newCertGenError(fmt.Sprintf("generate %s key", spec.name), err)
+		}
+		return ecdsa.GenerateKey(curve, rand.Reader)
+	case "", esv1.KeyAlgorithmRSA:
+		size := spec.rsaKeySize
+		if size == 0 {
+			size = rsaKeySize
+		}
+		return rsa.GenerateKey(rand.Reader, size)
+	default:
+		return nil, newCertGenError(fmt.Sprintf("generate %s key", spec.name), fmt.Errorf("unsupported key algorithm %q", spec.keyAlgorithm))
+	}
+}
+
+func ellipticCurve(curve esv1.ECDSACurve) (elliptic.Curve, error) {
+	switch curve {
+	case "", esv1.ECDSACurveP256:
+		return elliptic.P256(), nil
+	case esv1.ECDSACurveP384:
+		return elliptic.P384(), nil
+	default:
+		return nil, fmt.Errorf("unsupported ecdsa curve %q", curve)
+	}
+}
+
+// generateCA mints a new self-signed root CA keypair for spec.
+func generateCA(spec certSpec) (*certBundle, error) {
+	key, err := generateKey(spec)
 	if err != nil {
-		logrus.Error("json Marshal error : ", err)
-		return err
+		return nil, newCertGenError("generate ca key", err)
 	}
-	f, err := os.Create(fmt.Sprintf("%s/ca-config.json", configDir))
-	_, err = f.Write(caConfigJSON)
+
+	serial, err := newSerialNumber()
 	if err != nil {
-		logrus.Error("Error creating ca-config.json: ", err)
-		return err
+		return nil, newCertGenError("generate ca serial", err)
+	}
+
+	subject := spec.subject
+	subject.CommonName = spec.commonName
+
+	validity := spec.validity
+	if validity == 0 {
+		validity = caValidity
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               subject,
+		DNSNames:              spec.hosts,
+		IPAddresses:           spec.ips,
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
 	}
 
-	reqCACSRJSON, _ := json.Marshal(caCSR)
-	f, err = os.Create(fmt.Sprintf("%s/ca-csr.json", configDir))
-	_, err = f.Write(reqCACSRJSON)
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
 	if err != nil {
-		logrus.Error("Error creating ca-csr.json: ", err)
-		return err
+		return nil, newCertGenError("sign ca certificate", err)
 	}
 
-	for k, v := range map[string]string{
-		"node":    "req-node-csr.json",
-		"sgadmin": "req-sgadmin-csr.json",
-		"kibana":  "req-kibana-csr.json",
-		"cerebro": "req-cerebro-csr.json",
-	} {
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, newCertGenError("parse ca certificate", err)
+	}
 
-		req := csr{
-			CN: k,
-			Hosts: []string{
-				"localhost",
-				fmt.Sprintf("%s-%s", k, clusterName),
-				fmt.Sprintf("%s.%s", fmt.Sprintf("%s-%s", k, clusterName), namespace),
-				fmt.Sprintf("%s.%s.svc.cluster.local", fmt.Sprintf("%s-%s", k, clusterName), namespace),
-			},
-			Key: key{
-				Algo: "rsa",
-				Size: 2048,
-			},
-			Names: []names{
-				names{
-					O:  "autogenerated",
-					OU: "elasticsearch cluster",
-					L:  "operator",
-				},
-			},
+	return bundleFromKey(key, cert, der)
+}
+
+// generateLeaf mints a server+client keypair for spec, signed by ca.
+func generateLeaf(spec certSpec, ca *x509.Certificate, caKey crypto.Signer) (*certBundle, error) {
+	key, err := generateKey(spec)
+	if err != nil {
+		return nil, newCertGenError(fmt.Sprintf("generate %s key", spec.name), err)
+	}
+
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, newCertGenError(fmt.Sprintf("generate %s serial", spec.name), err)
+	}
+
+	subject := spec.subject
+	subject.CommonName = spec.commonName
+
+	validity := spec.validity
+	if validity == 0 {
+		validity = certValidity
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      subject,
+		DNSNames:     spec.hosts,
+		IPAddresses:  spec.ips,
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, key.Public(), caKey)
+	if err != nil {
+		return nil, newCertGenError(fmt.Sprintf("sign %s certificate", spec.name), err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, newCertGenError(fmt.Sprintf("parse %s certificate", spec.name), err)
+	}
+
+	return bundleFromKey(key, cert, der)
+}
+
+func bundleFromKey(key crypto.Signer, cert *x509.Certificate, der []byte) (*certBundle, error) {
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, newCertGenError("marshal pkcs8 key", err)
+	}
+
+	keyPEM, err := marshalKeyPEM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &certBundle{
+		cert:        cert,
+		certDER:     der,
+		certPEM:     pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		key:         key,
+		keyPEM:      keyPEM,
+		keyPKCS8DER: pkcs8,
+		keyPKCS8PEM: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}),
+	}, nil
+}
+
+// marshalKeyPEM encodes key in its algorithm-native PEM form (PKCS#1 for
+// RSA, SEC1 for ECDSA), matching what most non-Go TLS tooling expects
+// alongside the PKCS#8 form.
+func marshalKeyPEM(key crypto.Signer) ([]byte, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}), nil
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return nil, newCertGenError("marshal ec private key", err)
 		}
+		return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+	default:
+		return nil, newCertGenError("marshal private key", fmt.Errorf("unsupported key type %T", key))
+	}
+}
+
+// decodeKeyPEM parses a private key PEM block produced by marshalKeyPEM, or
+// a PKCS#8 block from an externally supplied keypair.
+func decodeKeyPEM(keyPEM []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("invalid key PEM")
+	}
 
-		configJSON, _ := json.Marshal(req)
-		f, err := os.Create(fmt.Sprintf("%s/%s", configDir, v))
-		_, err = f.Write(configJSON)
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
 		if err != nil {
-			logrus.Error(err)
-			return err
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("key of type %T is not a crypto.Signer", key)
 		}
+		return signer, nil
 	}
+}
+
+// toPKCS12 bundles bundle's leaf cert, key and the issuing CA into a single
+// PKCS#12 archive, replacing the `openssl pkcs12 -export` step.
+func toPKCS12(bundle *certBundle, ca *x509.Certificate) ([]byte, error) {
+	data, err := pkcs12.Encode(rand.Reader, bundle.key, bundle.cert, []*x509.Certificate{ca}, keystorePassword)
+	if err != nil {
+		return nil, newCertGenError("encode pkcs12", err)
+	}
+	return data, nil
+}
+
+// toPrivateKeyStore encodes bundle's key and cert chain as a JKS keystore
+// under alias, replacing `keytool -importkeystore`.
+func toPrivateKeyStore(bundle *certBundle, ca *x509.Certificate, alias string) ([]byte, error) {
+	ks := keystore.KeyStore{
+		alias: &keystore.PrivateKeyEntry{
+			Entry:   keystore.Entry{CreationDate: time.Now()},
+			PrivKey: bundle.keyPKCS8DER,
+			CertChain: []keystore.Certificate{
+				{Type: "X509", Content: bundle.certDER},
+				{Type: "X509", Content: ca.Raw},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := keystore.Encode(&buf, ks, []byte(keystorePassword)); err != nil {
+		return nil, newCertGenError("encode jks keystore", err)
+	}
+	return buf.Bytes(), nil
+}
 
+// toTrustStore encodes ca as a JKS truststore under alias, replacing
+// `keytool -import`.
+func toTrustStore(ca *x509.Certificate, alias string) ([]byte, error) {
+	ks := keystore.KeyStore{
+		alias: &keystore.TrustedCertificateEntry{
+			Entry:       keystore.Entry{CreationDate: time.Now()},
+			Certificate: keystore.Certificate{Type: "X509", Content: ca.Raw},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := keystore.Encode(&buf, ks, []byte(keystorePassword)); err != nil {
+		return nil, newCertGenError("encode jks truststore", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func writeBundle(certsDir, name string, bundle *certBundle) error {
+	if err := ioutil.WriteFile(fmt.Sprintf("%s/%s.pem", certsDir, name), bundle.certPEM, 0600); err != nil {
+		return newCertGenError(fmt.Sprintf("write %s cert", name), err)
+	}
+	if err := ioutil.WriteFile(fmt.Sprintf("%s/%s-key.pem", certsDir, name), bundle.keyPEM, 0600); err != nil {
+		return newCertGenError(fmt.Sprintf("write %s key", name), err)
+	}
 	return nil
 }
 
-// GenerateCerts creates certs
+// GenerateCerts creates the CA and per-component certs entirely in-process
+// (no cfssl/openssl/keytool subprocesses), using the operator's default key
+// algorithm/size/SANs/validity, and writes the PEM, PKCS#8, PKCS#12 and JKS
+// artifacts that CreateCertsSecret expects to find under certsDir.
 func (k *K8sutil) GenerateCerts(configDir, certsDir, namespace, clusterName string) error {
-	// Remove any existing config/certs
+	return k.GenerateCertsWithSpecs(configDir, certsDir, namespace, clusterName, esv1.CertsSpec{}, esv1.TLSSpec{})
+}
+
+// GenerateCertsWithSpecs is GenerateCerts with certs.CAValidity/CertValidity
+// and tls applied on top of the operator's defaults for the CA and for each
+// of node/sgadmin/kibana/cerebro. configDir is retained for API
+// compatibility but is no longer used to stage intermediate cfssl config
+// files.
+func (k *K8sutil) GenerateCertsWithSpecs(configDir, certsDir, namespace, clusterName string, certs esv1.CertsSpec, tls esv1.TLSSpec) error {
 	cleanUp(certsDir)
 	cleanUp(configDir)
 
-	// Generate new config
-	if err := k.generateConfig(configDir, certsDir, namespace, clusterName); err != nil {
+	caSpec, leafSpecs := buildCertSpecs(namespace, clusterName, certs, tls)
+
+	logrus.Info("Creating ca cert...")
+	ca, err := generateCA(caSpec)
+	if err != nil {
 		logrus.Error(err)
 		return err
 	}
-
-	// Generate CA Cert
-	logrus.Info("Creating ca cert...")
-	cmdCA1 := exec.Command("cfssl", "gencert", "-initca", fmt.Sprintf("%s/ca-csr.json", configDir))
-	cmdCA2 := exec.Command("cfssljson", "-bare", fmt.Sprintf("%s/ca", certsDir))
-	if _, err := pipeCommands(cmdCA1, cmdCA2); err != nil {
+	if err := writeBundle(certsDir, "ca", ca); err != nil {
 		logrus.Error(err)
 		return err
 	}
 
-	// Generate client Certs
-	for _, name := range []string{"node", "kibana", "cerebro", "sgadmin"} {
-
-		logrus.Infof("Creating %s cert...", name)
-		cmd1 := exec.Command("cfssl", "gencert", "-ca", fmt.Sprintf("%s/ca.pem", certsDir), "-ca-key", fmt.Sprintf("%s/ca-key.pem", certsDir), "-config", fmt.Sprintf("%s/ca-config.json", configDir), "-profile=server", fmt.Sprintf("%s/req-%s-csr.json", configDir, name))
-		cmd2 := exec.Command("cfssljson", "-bare", fmt.Sprintf("%s/%s", certsDir, name))
-		if _, err := pipeCommands(cmd1, cmd2); err != nil {
+	bundles := make(map[string]*certBundle, len(leafSpecs))
+	for _, spec := range leafSpecs {
+		logrus.Infof("Creating %s cert...", spec.name)
+		bundle, err := generateLeaf(spec, ca.cert, ca.key)
+		if err != nil {
 			logrus.Error(err)
 			return err
 		}
+		if err := writeBundle(certsDir, spec.name, bundle); err != nil {
+			logrus.Error(err)
+			return err
+		}
+		bundles[spec.name] = bundle
 	}
 
+	return writeDerivedArtifacts(certsDir, ca.cert, bundles)
+}
+
+// writeDerivedArtifacts produces the PKCS#8, PKCS#12 and JKS encodings that
+// are derived from an already-issued CA and leaf bundles, and writes them
+// under certsDir. It is shared by every path that can produce a CA +
+// node/sgadmin/kibana/cerebro bundle set, regardless of where the CA itself
+// came from.
+func writeDerivedArtifacts(certsDir string, ca *x509.Certificate, bundles map[string]*certBundle) error {
 	logrus.Info("Converting node to pkcs8...")
-	cmdConvertNodePkcs8 := exec.Command("openssl", "pkcs8", "-topk8", "-in", fmt.Sprintf("%s/node-key.pem", certsDir), "-out", fmt.Sprintf("%s/node-key.pkcs8.pem", certsDir), "-nocrypt")
-	if out, err := cmdConvertNodePkcs8.Output(); err != nil {
-		logrus.Error(string(out), err)
+	if err := ioutil.WriteFile(fmt.Sprintf("%s/node-key.pkcs8.pem", certsDir), bundles["node"].keyPKCS8PEM, 0600); err != nil {
+		err = newCertGenError("write node pkcs8 key", err)
+		logrus.Error(err)
 		return err
 	}
 
 	logrus.Info("Converting sgadmin to pkcs12...")
-	cmdConvertSgadmin := exec.Command("openssl", "pkcs12", "-export", "-inkey", fmt.Sprintf("%s/sgadmin-key.pem", certsDir), "-in", fmt.Sprintf("%s/sgadmin.pem", certsDir), "-out", fmt.Sprintf("%s/sgadmin.pkcs12", certsDir), "-password", "pass:changeit", "-certfile", fmt.Sprintf("%s/ca.pem", certsDir))
-	if out, err := cmdConvertSgadmin.Output(); err != nil {
-		logrus.Error(string(out), err)
+	sgadminPKCS12, err := toPKCS12(bundles["sgadmin"], ca)
+	if err != nil {
+		logrus.Error(err)
+		return err
+	}
+	if err := ioutil.WriteFile(fmt.Sprintf("%s/sgadmin.pkcs12", certsDir), sgadminPKCS12, 0600); err != nil {
+		err = newCertGenError("write sgadmin pkcs12", err)
+		logrus.Error(err)
 		return err
 	}
 
 	logrus.Info("Converting node to pkcs12...")
-	cmdConvertNode := exec.Command("openssl", "pkcs12", "-export", "-inkey", fmt.Sprintf("%s/node-key.pem", certsDir), "-in", fmt.Sprintf("%s/node.pem", certsDir), "-out", fmt.Sprintf("%s/node.pkcs12", certsDir), "-password", "pass:changeit", "-certfile", fmt.Sprintf("%s/ca.pem", certsDir))
-	if out, err := cmdConvertNode.Output(); err != nil {
-		logrus.Error(string(out), err)
+	nodePKCS12, err := toPKCS12(bundles["node"], ca)
+	if err != nil {
+		logrus.Error(err)
+		return err
+	}
+	if err := ioutil.WriteFile(fmt.Sprintf("%s/node.pkcs12", certsDir), nodePKCS12, 0600); err != nil {
+		err = newCertGenError("write node pkcs12", err)
+		logrus.Error(err)
 		return err
 	}
 
 	logrus.Info("Converting ca cert to jks...")
-	cmdCAJKS := exec.Command("keytool", "-import", "-file", fmt.Sprintf("%s/ca.pem", certsDir), "-alias", "root-ca", "-keystore", fmt.Sprintf("%s/truststore.jks", certsDir),
-		"-storepass", "changeit", "-srcstoretype", "pkcs12", "-noprompt")
-	if out, err := cmdCAJKS.Output(); err != nil {
-		logrus.Error(string(out), err)
+	trustStore, err := toTrustStore(ca, "root-ca")
+	if err != nil {
+		logrus.Error(err)
+		return err
+	}
+	if err := ioutil.WriteFile(fmt.Sprintf("%s/truststore.jks", certsDir), trustStore, 0600); err != nil {
+		err = newCertGenError("write truststore", err)
+		logrus.Error(err)
 		return err
 	}
 
 	logrus.Info("Converting sgadmin cert to jks...")
-	cmdSgadminJKS := exec.Command("keytool", "-importkeystore", "-srckeystore", fmt.Sprintf("%s/sgadmin.pkcs12", certsDir), "-srcalias", "1", "-destkeystore", fmt.Sprintf("%s/sgadmin-keystore.jks", certsDir),
-		"-storepass", "changeit", "-srcstoretype", "pkcs12", "-srcstorepass", "changeit", "-destalias", "elasticsearch-admin")
-	if out, err := cmdSgadminJKS.Output(); err != nil {
-		logrus.Error(string(out), err)
+	sgadminKeyStore, err := toPrivateKeyStore(bundles["sgadmin"], ca, "elasticsearch-admin")
+	if err != nil {
+		logrus.Error(err)
+		return err
+	}
+	if err := ioutil.WriteFile(fmt.Sprintf("%s/sgadmin-keystore.jks", certsDir), sgadminKeyStore, 0600); err != nil {
+		err = newCertGenError("write sgadmin keystore", err)
+		logrus.Error(err)
 		return err
 	}
 
 	logrus.Info("Converting node cert to jks...")
-	cmdNodeJKS := exec.Command("keytool", "-importkeystore", "-srckeystore", fmt.Sprintf("%s/node.pkcs12", certsDir), "-srcalias", "1", "-destkeystore", fmt.Sprintf("%s/node-keystore.jks", certsDir),
-		"-storepass", "changeit", "-srcstoretype", "pkcs12", "-srcstorepass", "changeit", "-destalias", "elasticsearch-node")
-	if out, err := cmdNodeJKS.Output(); err != nil {
-		logrus.Error(string(out), err)
+	nodeKeyStore, err := toPrivateKeyStore(bundles["node"], ca, "elasticsearch-node")
+	if err != nil {
+		logrus.Error(err)
+		return err
+	}
+	if err := ioutil.WriteFile(fmt.Sprintf("%s/node-keystore.jks", certsDir), nodeKeyStore, 0600); err != nil {
+		err = newCertGenError("write node keystore", err)
+		logrus.Error(err)
 		return err
 	}
 
@@ -267,8 +622,12 @@ func (k *K8sutil) DeleteCertsSecret(namespace, clusterName string) error {
 	return k.Kclient.CoreV1().Secrets(namespace).Delete(fmt.Sprintf("%s-%s", secretName, clusterName), &metav1.DeleteOptions{})
 }
 
-// CreateCertsSecret creates the certs secrets
-func (k *K8sutil) CreateCertsSecret(namespace, clusterName, certsDir string) error {
+// CreateCertsSecret creates the certs secret, recording tls as an
+// annotation so the key algorithm/size/SANs/validity overrides used to
+// produce it can be audited later. tls is recorded as passed in: fields it
+// leaves unset are recorded unset, not as the defaults they resolve to, and
+// CertsSpec validities are not recorded.
+func (k *K8sutil) CreateCertsSecret(namespace, clusterName, certsDir string, tls esv1.TLSSpec) error {
 	// Read certs from disk
 	nodeKeyStore, err := ioutil.ReadFile(fmt.Sprintf("%s/node-keystore.jks", certsDir))
 	if err != nil {
@@ -295,9 +654,17 @@ func (k *K8sutil) CreateCertsSecret(namespace, clusterName, certsDir string) err
 	kibana, _ := ioutil.ReadFile(fmt.Sprintf("%s/kibana.pem", certsDir))
 	cerebroKey, _ := ioutil.ReadFile(fmt.Sprintf("%s/cerebro-key.pem", certsDir))
 	cerebro, _ := ioutil.ReadFile(fmt.Sprintf("%s/cerebro.pem", certsDir))
+
+	annotations, err := tlsConfigAnnotations(tls)
+	if err != nil {
+		logrus.Error(err)
+		return err
+	}
+
 	secret := &v1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: fmt.Sprintf("%s-%s", secretName, clusterName),
+			Name:        fmt.Sprintf("%s-%s", secretName, clusterName),
+			Annotations: annotations,
 		},
 		Data: map[string][]byte{
 			"node-keystore.jks":    nodeKeyStore,
@@ -325,6 +692,16 @@ func (k *K8sutil) CreateCertsSecret(namespace, clusterName, certsDir string) err
 	return nil
 }
 
+// tlsConfigAnnotations renders tls as the annotation map recorded on the
+// certs secret for auditability.
+func tlsConfigAnnotations(tls esv1.TLSSpec) (map[string]string, error) {
+	encoded, err := json.Marshal(tls)
+	if err != nil {
+		return nil, newCertGenError("marshal tls config annotation", err)
+	}
+	return map[string]string{tlsConfigAnnotation: string(encoded)}, nil
+}
+
 func cleanUp(dir string) error {
 	d, err := os.Open(dir)
 	if err != nil {
@@ -343,20 +720,3 @@ func cleanUp(dir string) error {
 	}
 	return nil
 }
-
-// https://gist.github.com/dagoof/1477401
-func pipeCommands(commands ...*exec.Cmd) ([]byte, error) {
-	for i, command := range commands[:len(commands)-1] {
-		out, err := command.StdoutPipe()
-		if err != nil {
-			return nil, err
-		}
-		command.Start()
-		commands[i+1].Stdin = out
-	}
-	final, err := commands[len(commands)-1].Output()
-	if err != nil {
-		return nil, err
-	}
-	return final, nil
-}
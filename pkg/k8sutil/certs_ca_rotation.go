@@ -0,0 +1,265 @@
+package k8sutil
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	keystore "github.com/pavlo-v-chernykh/keystore-go"
+
+	"github.com/Sirupsen/logrus"
+	esv1 "github.com/sysbart/elasticsearch-operator/pkg/apis/elasticsearchoperator/v1"
+)
+
+const (
+	// caPrimaryKey holds the id of the CA keypair that new leaves are
+	// signed with.
+	caPrimaryKey = "ca-primary"
+
+	// legacyCAID is assigned to a secret's ca.pem/ca-key.pem when it
+	// predates multi-CA support, so it can be migrated into the ca-<id>
+	// scheme on first rotation.
+	legacyCAID = "legacy"
+)
+
+// caKeyEntry is one CA keypair stored in a cluster's certs secret,
+// identified by a short id. Several can coexist during a rotation: the
+// primary signs new leaves, the rest are kept only as trusted issuers.
+type caKeyEntry struct {
+	id      string
+	cert    *x509.Certificate
+	key     crypto.Signer
+	primary bool
+}
+
+// RotateCA rolls the cluster's root CA the way kops rotates keypairs: a new
+// CA is appended to the secret and marked primary, every leaf (including
+// sgadmin) is reissued under it, and the truststore is rebuilt to trust
+// both the new and the retired CA. The retired CA keeps signing validity
+// for any leaf that hasn't rolled yet, so searchguard admin auth keeps
+// working throughout the rollout. Call PruneRetiredCAs once a full rolling
+// restart has completed to drop the retired CA from the truststore.
+func (k *K8sutil) RotateCA(namespace, clusterName string, certs esv1.CertsSpec, tls esv1.TLSSpec) ([]string, error) {
+	secret, err := k.Kclient.CoreV1().Secrets(namespace).Get(fmt.Sprintf("%s-%s", secretName, clusterName), metav1.GetOptions{})
+	if err != nil {
+		return nil, newCertGenError("load certs secret", err)
+	}
+
+	cas, err := decodeAllCAs(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	caSpec, leafSpecs := buildCertSpecs(namespace, clusterName, certs, tls)
+	newCA, err := generateCA(caSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range cas {
+		cas[i].primary = false
+	}
+	cas = append(cas, caKeyEntry{id: newCAID(), cert: newCA.cert, key: newCA.key, primary: true})
+
+	if err := storeCAs(secret, cas); err != nil {
+		return nil, err
+	}
+
+	trustStore, err := toTrustStoreMulti(cas)
+	if err != nil {
+		return nil, err
+	}
+	secret.Data["truststore.jks"] = trustStore
+
+	var reissued []string
+	for _, spec := range leafSpecs {
+		logrus.Infof("Reissuing %s cert under rotated ca...", spec.name)
+		bundle, err := generateLeaf(spec, newCA.cert, newCA.key)
+		if err != nil {
+			return reissued, err
+		}
+		if err := updateLeafInSecret(secret, newCA.cert, spec.name, bundle); err != nil {
+			return reissued, err
+		}
+		reissued = append(reissued, spec.name)
+	}
+
+	if _, err := k.Kclient.CoreV1().Secrets(namespace).Update(secret); err != nil {
+		return reissued, newCertGenError("update certs secret", err)
+	}
+
+	if err := k.restartDependents(namespace, clusterName); err != nil {
+		logrus.Error(err)
+	}
+
+	return reissued, nil
+}
+
+// PruneRetiredCAs drops every non-primary CA from the cluster's certs
+// secret and rebuilds the truststore to trust only the current primary.
+// CertRotator calls this automatically once rolloutComplete confirms every
+// elasticsearch/kibana/cerebro pod has rolled onto the leaves issued by the
+// current primary CA; calling it any earlier risks rejecting a still-running
+// pod that's presenting leaves signed by the CA being dropped.
+func (k *K8sutil) PruneRetiredCAs(namespace, clusterName string) error {
+	secret, err := k.Kclient.CoreV1().Secrets(namespace).Get(fmt.Sprintf("%s-%s", secretName, clusterName), metav1.GetOptions{})
+	if err != nil {
+		return newCertGenError("load certs secret", err)
+	}
+
+	cas, err := decodeAllCAs(secret)
+	if err != nil {
+		return err
+	}
+
+	var primary *caKeyEntry
+	for i := range cas {
+		if cas[i].primary {
+			primary = &cas[i]
+			break
+		}
+	}
+	if primary == nil {
+		return newCertGenError("prune retired cas", fmt.Errorf("no primary ca found in secret"))
+	}
+
+	for _, ca := range cas {
+		if ca.id == primary.id {
+			continue
+		}
+		delete(secret.Data, fmt.Sprintf("ca-%s.pem", ca.id))
+		delete(secret.Data, fmt.Sprintf("ca-%s-key.pem", ca.id))
+	}
+
+	retained := []caKeyEntry{*primary}
+	if err := storeCAs(secret, retained); err != nil {
+		return err
+	}
+
+	trustStore, err := toTrustStoreMulti(retained)
+	if err != nil {
+		return err
+	}
+	secret.Data["truststore.jks"] = trustStore
+
+	if _, err := k.Kclient.CoreV1().Secrets(namespace).Update(secret); err != nil {
+		return newCertGenError("update certs secret", err)
+	}
+	return nil
+}
+
+// hasRetiredCA reports whether the cluster's certs secret holds more than
+// one CA, i.e. a rotation has appended a new primary but the old one hasn't
+// been pruned yet.
+func (k *K8sutil) hasRetiredCA(namespace, clusterName string) (bool, error) {
+	secret, err := k.Kclient.CoreV1().Secrets(namespace).Get(fmt.Sprintf("%s-%s", secretName, clusterName), metav1.GetOptions{})
+	if err != nil {
+		return false, newCertGenError("load certs secret", err)
+	}
+
+	cas, err := decodeAllCAs(secret)
+	if err != nil {
+		return false, err
+	}
+	return len(cas) > 1, nil
+}
+
+// decodeAllCAs returns every CA keypair stored in secret. Secrets written
+// before multi-CA support only have ca.pem/ca-key.pem; those are reported
+// as a single primary CA with id legacyCAID.
+func decodeAllCAs(secret *v1.Secret) ([]caKeyEntry, error) {
+	ids := map[string]bool{}
+	for key := range secret.Data {
+		if !strings.HasPrefix(key, "ca-") || !strings.HasSuffix(key, ".pem") || strings.HasSuffix(key, "-key.pem") {
+			continue
+		}
+		ids[strings.TrimSuffix(strings.TrimPrefix(key, "ca-"), ".pem")] = true
+	}
+
+	if len(ids) == 0 {
+		cert, key, err := decodeCAFromSecret(secret)
+		if err != nil {
+			return nil, err
+		}
+		return []caKeyEntry{{id: legacyCAID, cert: cert, key: key, primary: true}}, nil
+	}
+
+	primaryID := string(secret.Data[caPrimaryKey])
+	if primaryID == "" {
+		return nil, newCertGenError("decode cas", fmt.Errorf("secret has ca-<id> entries but no %s marker", caPrimaryKey))
+	}
+
+	cas := make([]caKeyEntry, 0, len(ids))
+	for id := range ids {
+		certPEM, ok := secret.Data[fmt.Sprintf("ca-%s.pem", id)]
+		if !ok {
+			return nil, newCertGenError("decode cas", fmt.Errorf("missing ca-%s.pem", id))
+		}
+		keyPEM, ok := secret.Data[fmt.Sprintf("ca-%s-key.pem", id)]
+		if !ok {
+			return nil, newCertGenError("decode cas", fmt.Errorf("missing ca-%s-key.pem", id))
+		}
+
+		cert, key, err := decodeCAKeypair(certPEM, keyPEM)
+		if err != nil {
+			return nil, err
+		}
+		cas = append(cas, caKeyEntry{id: id, cert: cert, key: key, primary: id == primaryID})
+	}
+
+	return cas, nil
+}
+
+// storeCAs writes every entry in cas into secret under ca-<id>.pem/
+// ca-<id>-key.pem, and mirrors the primary onto the legacy ca.pem/
+// ca-key.pem keys that the rest of the pipeline (and older secrets) expect.
+func storeCAs(secret *v1.Secret, cas []caKeyEntry) error {
+	for _, ca := range cas {
+		certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})
+		keyPEM, err := marshalKeyPEM(ca.key)
+		if err != nil {
+			return err
+		}
+
+		secret.Data[fmt.Sprintf("ca-%s.pem", ca.id)] = certPEM
+		secret.Data[fmt.Sprintf("ca-%s-key.pem", ca.id)] = keyPEM
+
+		if ca.primary {
+			secret.Data[caPrimaryKey] = []byte(ca.id)
+			secret.Data["ca.pem"] = certPEM
+			secret.Data["ca-key.pem"] = keyPEM
+		}
+	}
+	return nil
+}
+
+// toTrustStoreMulti encodes every CA in cas as a trusted entry in a single
+// JKS truststore, each under its own root-ca-<id> alias.
+func toTrustStoreMulti(cas []caKeyEntry) ([]byte, error) {
+	ks := keystore.KeyStore{}
+	for _, ca := range cas {
+		ks[fmt.Sprintf("root-ca-%s", ca.id)] = &keystore.TrustedCertificateEntry{
+			Entry:       keystore.Entry{CreationDate: time.Now()},
+			Certificate: keystore.Certificate{Type: "X509", Content: ca.cert.Raw},
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := keystore.Encode(&buf, ks, []byte(keystorePassword)); err != nil {
+		return nil, newCertGenError("encode jks truststore", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// newCAID returns a short, collision-resistant identifier for a freshly
+// minted CA keypair.
+func newCAID() string {
+	return fmt.Sprintf("%x", time.Now().UnixNano())
+}
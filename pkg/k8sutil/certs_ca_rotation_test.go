@@ -0,0 +1,78 @@
+package k8sutil
+
+import (
+	"testing"
+
+	"k8s.io/api/core/v1"
+
+	esv1 "github.com/sysbart/elasticsearch-operator/pkg/apis/elasticsearchoperator/v1"
+)
+
+func TestDecodeAllCAsMigratesLegacySecret(t *testing.T) {
+	caSpec, _ := buildCertSpecs("default", "test-cluster", esv1.CertsSpec{}, esv1.TLSSpec{})
+	ca, err := generateCA(caSpec)
+	if err != nil {
+		t.Fatalf("generateCA() error = %v", err)
+	}
+
+	secret := &v1.Secret{
+		Data: map[string][]byte{
+			"ca.pem":     ca.certPEM,
+			"ca-key.pem": ca.keyPEM,
+		},
+	}
+
+	cas, err := decodeAllCAs(secret)
+	if err != nil {
+		t.Fatalf("decodeAllCAs() error = %v", err)
+	}
+	if len(cas) != 1 || cas[0].id != legacyCAID || !cas[0].primary {
+		t.Fatalf("decodeAllCAs() = %+v, want single legacy primary CA", cas)
+	}
+}
+
+func TestStoreCAsRoundTrip(t *testing.T) {
+	caSpec, _ := buildCertSpecs("default", "test-cluster", esv1.CertsSpec{}, esv1.TLSSpec{})
+	oldCA, err := generateCA(caSpec)
+	if err != nil {
+		t.Fatalf("generateCA() error = %v", err)
+	}
+	newCA, err := generateCA(caSpec)
+	if err != nil {
+		t.Fatalf("generateCA() error = %v", err)
+	}
+
+	secret := &v1.Secret{Data: map[string][]byte{}}
+	cas := []caKeyEntry{
+		{id: "old", cert: oldCA.cert, key: oldCA.key, primary: false},
+		{id: "new", cert: newCA.cert, key: newCA.key, primary: true},
+	}
+	if err := storeCAs(secret, cas); err != nil {
+		t.Fatalf("storeCAs() error = %v", err)
+	}
+
+	roundTripped, err := decodeAllCAs(secret)
+	if err != nil {
+		t.Fatalf("decodeAllCAs() error = %v", err)
+	}
+	if len(roundTripped) != 2 {
+		t.Fatalf("decodeAllCAs() returned %d entries, want 2", len(roundTripped))
+	}
+
+	var primaryCount int
+	for _, ca := range roundTripped {
+		if ca.primary {
+			primaryCount++
+			if ca.id != "new" {
+				t.Fatalf("primary CA id = %q, want %q", ca.id, "new")
+			}
+		}
+	}
+	if primaryCount != 1 {
+		t.Fatalf("found %d primary CAs, want exactly 1", primaryCount)
+	}
+
+	if _, err := toTrustStoreMulti(roundTripped); err != nil {
+		t.Fatalf("toTrustStoreMulti() error = %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+package k8sutil
+
+import (
+	"fmt"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	cmv1 "github.com/jetstack/cert-manager/pkg/apis/certmanager/v1"
+	cmmeta "github.com/jetstack/cert-manager/pkg/apis/meta/v1"
+	cmclientset "github.com/jetstack/cert-manager/pkg/client/clientset/versioned"
+
+	"github.com/Sirupsen/logrus"
+	esv1 "github.com/sysbart/elasticsearch-operator/pkg/apis/elasticsearchoperator/v1"
+)
+
+// certManagerPollInterval controls how often GenerateCertsWithCertManager
+// polls for cert-manager to populate a component's secret.
+const certManagerPollInterval = 2 * time.Second
+
+// GenerateCertsWithExternalCA builds the node/sgadmin/kibana/cerebro leaf
+// certs for a cluster from a CA loaded out of an existing Secret (ref),
+// instead of minting a new self-signed root, and writes the same PEM,
+// PKCS#8, PKCS#12 and JKS artifacts under certsDir that GenerateCerts does.
+func (k *K8sutil) GenerateCertsWithExternalCA(namespace, clusterName, certsDir string, ref esv1.CAFromSecretRef, tls esv1.TLSSpec) error {
+	cleanUp(certsDir)
+
+	caSecret, err := k.Kclient.CoreV1().Secrets(namespace).Get(ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return newCertGenError("load external ca secret", err)
+	}
+
+	caCert, caKey, err := decodeCAFromSecret(caSecret)
+	if err != nil {
+		return err
+	}
+
+	ca := &certBundle{
+		cert:    caCert,
+		certPEM: caSecret.Data["ca.pem"],
+		keyPEM:  caSecret.Data["ca-key.pem"],
+	}
+	if err := writeBundle(certsDir, "ca", ca); err != nil {
+		logrus.Error(err)
+		return err
+	}
+
+	_, leafSpecs := buildCertSpecs(namespace, clusterName, esv1.CertsSpec{}, tls)
+	bundles := make(map[string]*certBundle, len(leafSpecs))
+	for _, spec := range leafSpecs {
+		logrus.Infof("Creating %s cert signed by external ca %s...", spec.name, ref.Name)
+		bundle, err := generateLeaf(spec, caCert, caKey)
+		if err != nil {
+			logrus.Error(err)
+			return err
+		}
+		if err := writeBundle(certsDir, spec.name, bundle); err != nil {
+			logrus.Error(err)
+			return err
+		}
+		bundles[spec.name] = bundle
+	}
+
+	return writeDerivedArtifacts(certsDir, caCert, bundles)
+}
+
+// GenerateCertsWithCertManager creates a cert-manager Certificate for each
+// of node/sgadmin/kibana/cerebro, issued by ref, and blocks until
+// cert-manager has populated every resulting Secret. It does not touch the
+// local PKI pipeline at all; the returned secret names are where the
+// operator should read the issued keypairs from.
+func (k *K8sutil) GenerateCertsWithCertManager(cmClient cmclientset.Interface, namespace, clusterName string, ref esv1.CertManagerIssuerRef, tls esv1.TLSSpec, timeout time.Duration) (map[string]string, error) {
+	kind := ref.Kind
+	if kind == "" {
+		kind = "Issuer"
+	}
+
+	_, leafSpecs := buildCertSpecs(namespace, clusterName, esv1.CertsSpec{}, tls)
+
+	secretNames := make(map[string]string, len(leafSpecs))
+	for _, spec := range leafSpecs {
+		certName := fmt.Sprintf("%s-%s", spec.name, clusterName)
+		leafSecretName := fmt.Sprintf("%s-tls", certName)
+
+		cert := &cmv1.Certificate{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      certName,
+				Namespace: namespace,
+			},
+			Spec: cmv1.CertificateSpec{
+				SecretName: leafSecretName,
+				CommonName: spec.commonName,
+				DNSNames:   spec.hosts,
+				IssuerRef: cmmeta.ObjectReference{
+					Name:  ref.Name,
+					Kind:  kind,
+					Group: "cert-manager.io",
+				},
+			},
+		}
+
+		logrus.Infof("Creating cert-manager Certificate for %s, issued by %s %s...", spec.name, kind, ref.Name)
+		if _, err := cmClient.CertmanagerV1().Certificates(namespace).Create(cert); err != nil && !apierrors.IsAlreadyExists(err) {
+			return nil, newCertGenError(fmt.Sprintf("create %s certificate", spec.name), err)
+		} else if err != nil {
+			logrus.Infof("cert-manager Certificate for %s already exists, waiting on its secret...", spec.name)
+		}
+
+		if err := k.waitForSecret(namespace, leafSecretName, timeout); err != nil {
+			return nil, newCertGenError(fmt.Sprintf("wait for %s certificate secret", spec.name), err)
+		}
+
+		secretNames[spec.name] = leafSecretName
+	}
+
+	return secretNames, nil
+}
+
+// waitForSecret polls until name exists in namespace or timeout elapses.
+func (k *K8sutil) waitForSecret(namespace, name string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if _, err := k.Kclient.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{}); err == nil {
+			return nil
+		}
+		time.Sleep(certManagerPollInterval)
+	}
+	return fmt.Errorf("timed out after %s waiting for secret %s/%s", timeout, namespace, name)
+}
@@ -0,0 +1,365 @@
+package k8sutil
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/Sirupsen/logrus"
+	esv1 "github.com/sysbart/elasticsearch-operator/pkg/apis/elasticsearchoperator/v1"
+)
+
+// defaultRenewThreshold is the fraction of a leaf cert's validity window
+// that must remain before the rotation controller leaves it alone.
+const defaultRenewThreshold = 1.0 / 3.0
+
+// CertRotator periodically checks one cluster's certs secret and reissues
+// leaf certs that are approaching expiry.
+type CertRotator struct {
+	k8sutil     *K8sutil
+	interval    time.Duration
+	namespace   string
+	clusterName string
+	certsSpec   esv1.CertsSpec
+	tlsSpec     esv1.TLSSpec
+	rotateCA    chan struct{}
+}
+
+// NewCertRotator builds a CertRotator for a single cluster. Callers run one
+// per watched ElasticsearchCluster resource.
+func NewCertRotator(k *K8sutil, interval time.Duration, namespace, clusterName string, certsSpec esv1.CertsSpec, tlsSpec esv1.TLSSpec) *CertRotator {
+	return &CertRotator{
+		k8sutil:     k,
+		interval:    interval,
+		namespace:   namespace,
+		clusterName: clusterName,
+		certsSpec:   certsSpec,
+		tlsSpec:     tlsSpec,
+		rotateCA:    make(chan struct{}, 1),
+	}
+}
+
+// RequestCARotation schedules an on-demand CA rotation on the next Run
+// iteration. The controller watching ElasticsearchCluster resources should
+// call this when it observes esv1.RotateCAAnnotation on the resource.
+func (r *CertRotator) RequestCARotation() {
+	select {
+	case r.rotateCA <- struct{}{}:
+	default:
+		// A rotation is already queued.
+	}
+}
+
+// Run blocks, checking for expiring certs every interval and servicing
+// on-demand CA rotation requests, until stopCh is closed.
+func (r *CertRotator) Run(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.pruneCAIfRolloutComplete()
+
+			rotated, err := r.k8sutil.RotateCertsIfNeeded(r.namespace, r.clusterName, r.certsSpec, r.tlsSpec)
+			if err != nil {
+				logrus.Errorf("cert rotation check failed for %s/%s: %v", r.namespace, r.clusterName, err)
+				continue
+			}
+			if len(rotated) > 0 {
+				logrus.Infof("rotated certs %v for %s/%s", rotated, r.namespace, r.clusterName)
+			}
+		case <-r.rotateCA:
+			logrus.Infof("on-demand CA rotation requested for %s/%s", r.namespace, r.clusterName)
+			if _, err := r.k8sutil.RotateCA(r.namespace, r.clusterName, r.certsSpec, r.tlsSpec); err != nil {
+				logrus.Errorf("ca rotation failed for %s/%s: %v", r.namespace, r.clusterName, err)
+			}
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// pruneCAIfRolloutComplete drops the retired CA from the truststore once
+// every Deployment/StatefulSet restarted by the last RotateCA has finished
+// rolling out, so a pod still starting up on the retired CA's leaves isn't
+// left trusting a truststore that no longer contains it. Whether a prune is
+// even pending is derived from the certs secret itself (more than one CA
+// stored means a retired one is still awaiting pruning) rather than tracked
+// in memory on CertRotator, so a prune that was interrupted by an operator
+// restart between RotateCA and PruneRetiredCAs is picked back up on the next
+// tick instead of being forgotten for the lifetime of the retired CA.
+func (r *CertRotator) pruneCAIfRolloutComplete() {
+	pending, err := r.k8sutil.hasRetiredCA(r.namespace, r.clusterName)
+	if err != nil {
+		logrus.Errorf("checking for retired cas for %s/%s: %v", r.namespace, r.clusterName, err)
+		return
+	}
+	if !pending {
+		return
+	}
+
+	complete, err := r.k8sutil.rolloutComplete(r.namespace, r.clusterName)
+	if err != nil {
+		logrus.Errorf("checking rollout status for %s/%s: %v", r.namespace, r.clusterName, err)
+		return
+	}
+	if !complete {
+		return
+	}
+
+	logrus.Infof("rollout complete for %s/%s, pruning retired cas", r.namespace, r.clusterName)
+	if err := r.k8sutil.PruneRetiredCAs(r.namespace, r.clusterName); err != nil {
+		logrus.Errorf("pruning retired cas for %s/%s: %v", r.namespace, r.clusterName, err)
+	}
+}
+
+// RotateCertsIfNeeded reissues any leaf cert in the cluster's certs secret
+// whose remaining validity has dropped below spec.RenewThreshold of its own
+// actual validity window, signing the replacement with the CA already
+// stored in the secret. It returns the names of the components that were
+// reissued and rolling-restarts their dependent workloads so the new
+// keystores are picked up.
+func (k *K8sutil) RotateCertsIfNeeded(namespace, clusterName string, spec esv1.CertsSpec, tls esv1.TLSSpec) ([]string, error) {
+	secret, err := k.Kclient.CoreV1().Secrets(namespace).Get(fmt.Sprintf("%s-%s", secretName, clusterName), metav1.GetOptions{})
+	if err != nil {
+		return nil, newCertGenError("load certs secret", err)
+	}
+
+	threshold := spec.RenewThreshold
+	if threshold <= 0 {
+		threshold = defaultRenewThreshold
+	}
+
+	caCert, caKey, err := decodeCAFromSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	_, leafSpecs := buildCertSpecs(namespace, clusterName, spec, tls)
+
+	var rotated []string
+	for _, leafSpec := range leafSpecs {
+		cert, err := decodeCertFromSecret(secret, leafSpec.name)
+		needsRotation := err != nil
+		if err != nil {
+			logrus.Warnf("could not decode %s cert from secret, reissuing: %v", leafSpec.name, err)
+		} else {
+			needsRotation = certNeedsRotation(cert, threshold)
+		}
+
+		if !needsRotation {
+			continue
+		}
+
+		logrus.Infof("%s cert for %s/%s is within its renewal threshold, reissuing", leafSpec.name, namespace, clusterName)
+		bundle, err := generateLeaf(leafSpec, caCert, caKey)
+		if err != nil {
+			return rotated, err
+		}
+
+		if err := updateLeafInSecret(secret, caCert, leafSpec.name, bundle); err != nil {
+			return rotated, err
+		}
+
+		rotated = append(rotated, leafSpec.name)
+	}
+
+	if len(rotated) == 0 {
+		return nil, nil
+	}
+
+	if _, err := k.Kclient.CoreV1().Secrets(namespace).Update(secret); err != nil {
+		return rotated, newCertGenError("update certs secret", err)
+	}
+
+	if err := k.restartDependents(namespace, clusterName); err != nil {
+		logrus.Error(err)
+	}
+
+	return rotated, nil
+}
+
+// certNeedsRotation reports whether cert's remaining validity has dropped
+// below threshold of its own NotBefore/NotAfter window. Comparing against
+// the cert's own window rather than a separately configured validity means
+// a mismatch between what was configured and what was actually issued can't
+// turn into a perpetual reissue loop (or a rotation that fires too late).
+func certNeedsRotation(cert *x509.Certificate, threshold float64) bool {
+	actualValidity := cert.NotAfter.Sub(cert.NotBefore)
+	return time.Until(cert.NotAfter) < time.Duration(threshold*float64(actualValidity))
+}
+
+// decodeCAFromSecret parses the primary CA keypair stored in the cluster's
+// certs secret under the well-known ca.pem/ca-key.pem keys.
+func decodeCAFromSecret(secret *v1.Secret) (*x509.Certificate, crypto.Signer, error) {
+	certPEM, ok := secret.Data["ca.pem"]
+	if !ok {
+		return nil, nil, newCertGenError("load ca cert", fmt.Errorf("ca.pem not found in secret"))
+	}
+	keyPEM, ok := secret.Data["ca-key.pem"]
+	if !ok {
+		return nil, nil, newCertGenError("load ca key", fmt.Errorf("ca-key.pem not found in secret"))
+	}
+
+	return decodeCAKeypair(certPEM, keyPEM)
+}
+
+// decodeCAKeypair parses a PEM-encoded CA cert and key pair, accepting RSA
+// or ECDSA keys in either their native or PKCS#8 PEM encoding.
+func decodeCAKeypair(certPEM, keyPEM []byte) (*x509.Certificate, crypto.Signer, error) {
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return nil, nil, newCertGenError("decode ca cert", fmt.Errorf("invalid ca certificate PEM"))
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, newCertGenError("parse ca cert", err)
+	}
+
+	key, err := decodeKeyPEM(keyPEM)
+	if err != nil {
+		return nil, nil, newCertGenError("parse ca key", err)
+	}
+
+	return cert, key, nil
+}
+
+// decodeCertFromSecret parses the named component's leaf cert from the secret.
+func decodeCertFromSecret(secret *v1.Secret, name string) (*x509.Certificate, error) {
+	certPEM, ok := secret.Data[fmt.Sprintf("%s.pem", name)]
+	if !ok {
+		return nil, newCertGenError(fmt.Sprintf("load %s cert", name), fmt.Errorf("%s.pem not found in secret", name))
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, newCertGenError(fmt.Sprintf("decode %s cert", name), fmt.Errorf("invalid PEM in %s.pem", name))
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// updateLeafInSecret writes a freshly issued bundle's artifacts into secret,
+// refreshing any JKS/PKCS#8 derivatives that depend on the component's key.
+func updateLeafInSecret(secret *v1.Secret, ca *x509.Certificate, name string, bundle *certBundle) error {
+	secret.Data[fmt.Sprintf("%s.pem", name)] = bundle.certPEM
+	secret.Data[fmt.Sprintf("%s-key.pem", name)] = bundle.keyPEM
+
+	switch name {
+	case "node":
+		secret.Data["node-key.pkcs8.pem"] = bundle.keyPKCS8PEM
+		keyStore, err := toPrivateKeyStore(bundle, ca, "elasticsearch-node")
+		if err != nil {
+			return err
+		}
+		secret.Data["node-keystore.jks"] = keyStore
+	case "sgadmin":
+		keyStore, err := toPrivateKeyStore(bundle, ca, "elasticsearch-admin")
+		if err != nil {
+			return err
+		}
+		secret.Data["sgadmin-keystore.jks"] = keyStore
+	}
+
+	return nil
+}
+
+// restartDependents forces a rolling restart of every Deployment and
+// StatefulSet belonging to clusterName by bumping a pod template
+// annotation, so the next rollout picks up the refreshed keystores. The
+// "cluster-name" label key is the convention this package assumes the
+// elasticsearch/kibana/cerebro workloads are created with; if nothing
+// matches, that assumption may be wrong for this cluster, so a warning is
+// logged rather than returning silently as if the restart had happened.
+func (k *K8sutil) restartDependents(namespace, clusterName string) error {
+	selector := fmt.Sprintf("cluster-name=%s", clusterName)
+
+	deployments, err := k.Kclient.AppsV1().Deployments(namespace).List(metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return newCertGenError("list deployments for rotation restart", err)
+	}
+	for i := range deployments.Items {
+		d := &deployments.Items[i]
+		bumpRestartAnnotation(&d.Spec.Template)
+		if _, err := k.Kclient.AppsV1().Deployments(namespace).Update(d); err != nil {
+			return newCertGenError(fmt.Sprintf("restart deployment %s", d.Name), err)
+		}
+	}
+
+	statefulSets, err := k.Kclient.AppsV1().StatefulSets(namespace).List(metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return newCertGenError("list statefulsets for rotation restart", err)
+	}
+	for i := range statefulSets.Items {
+		s := &statefulSets.Items[i]
+		bumpRestartAnnotation(&s.Spec.Template)
+		if _, err := k.Kclient.AppsV1().StatefulSets(namespace).Update(s); err != nil {
+			return newCertGenError(fmt.Sprintf("restart statefulset %s", s.Name), err)
+		}
+	}
+
+	if len(deployments.Items) == 0 && len(statefulSets.Items) == 0 {
+		logrus.Warnf("rotation restart for %s/%s matched no Deployments or StatefulSets with selector %q; new certs were written to the secret but no pod will pick them up", namespace, clusterName, selector)
+	}
+
+	return nil
+}
+
+func bumpRestartAnnotation(tmpl *v1.PodTemplateSpec) {
+	if tmpl.Annotations == nil {
+		tmpl.Annotations = map[string]string{}
+	}
+	tmpl.Annotations["elasticsearch-operator/cert-rotated-at"] = time.Now().Format(time.RFC3339)
+}
+
+// rolloutComplete reports whether every Deployment and StatefulSet
+// belonging to clusterName has finished rolling out its pod template, i.e.
+// every replica has been updated to the latest revision and is ready. It's
+// used to gate pruning a retired CA from the truststore until no running
+// pod can still be presenting leaves signed by it.
+func (k *K8sutil) rolloutComplete(namespace, clusterName string) (bool, error) {
+	selector := fmt.Sprintf("cluster-name=%s", clusterName)
+
+	deployments, err := k.Kclient.AppsV1().Deployments(namespace).List(metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return false, newCertGenError("list deployments for rollout check", err)
+	}
+	for i := range deployments.Items {
+		d := &deployments.Items[i]
+		replicas := int32(1)
+		if d.Spec.Replicas != nil {
+			replicas = *d.Spec.Replicas
+		}
+		if !workloadRolloutComplete(d.Generation, d.Status.ObservedGeneration, replicas, d.Status.UpdatedReplicas, d.Status.ReadyReplicas) {
+			return false, nil
+		}
+	}
+
+	statefulSets, err := k.Kclient.AppsV1().StatefulSets(namespace).List(metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return false, newCertGenError("list statefulsets for rollout check", err)
+	}
+	for i := range statefulSets.Items {
+		s := &statefulSets.Items[i]
+		replicas := int32(1)
+		if s.Spec.Replicas != nil {
+			replicas = *s.Spec.Replicas
+		}
+		if !workloadRolloutComplete(s.Generation, s.Status.ObservedGeneration, replicas, s.Status.UpdatedReplicas, s.Status.ReadyReplicas) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// workloadRolloutComplete reports whether a Deployment/StatefulSet's
+// controller has caught up with the latest pod template generation and
+// every replica has been updated to it and is ready.
+func workloadRolloutComplete(generation, observedGeneration int64, replicas, updatedReplicas, readyReplicas int32) bool {
+	return observedGeneration >= generation && updatedReplicas >= replicas && readyReplicas >= replicas
+}
@@ -0,0 +1,104 @@
+package k8sutil
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+
+	esv1 "github.com/sysbart/elasticsearch-operator/pkg/apis/elasticsearchoperator/v1"
+)
+
+func TestCertNeedsRotation(t *testing.T) {
+	validity := 8760 * time.Hour
+	threshold := 1.0 / 3.0
+
+	tests := []struct {
+		name      string
+		remaining time.Duration
+		want      bool
+	}{
+		{"plenty of validity left", validity, false},
+		{"just under the threshold", time.Duration(float64(validity) * 0.3), true},
+		{"already expired", -time.Hour, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			notAfter := time.Now().Add(tt.remaining)
+			cert := &x509.Certificate{NotBefore: notAfter.Add(-validity), NotAfter: notAfter}
+			got := certNeedsRotation(cert, threshold)
+			if got != tt.want {
+				t.Fatalf("certNeedsRotation() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeCAFromSecretRoundTrip(t *testing.T) {
+	caSpec, _ := buildCertSpecs("default", "test-cluster", esv1.CertsSpec{}, esv1.TLSSpec{})
+	ca, err := generateCA(caSpec)
+	if err != nil {
+		t.Fatalf("generateCA() error = %v", err)
+	}
+
+	secret := &v1.Secret{
+		Data: map[string][]byte{
+			"ca.pem":     ca.certPEM,
+			"ca-key.pem": ca.keyPEM,
+		},
+	}
+
+	cert, key, err := decodeCAFromSecret(secret)
+	if err != nil {
+		t.Fatalf("decodeCAFromSecret() error = %v", err)
+	}
+	if cert.SerialNumber.Cmp(ca.cert.SerialNumber) != 0 {
+		t.Fatalf("decoded ca serial = %v, want %v", cert.SerialNumber, ca.cert.SerialNumber)
+	}
+
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		t.Fatalf("decoded ca key type = %T, want *rsa.PrivateKey", key)
+	}
+	originalKey, ok := ca.key.(*rsa.PrivateKey)
+	if !ok {
+		t.Fatalf("generated ca key type = %T, want *rsa.PrivateKey", ca.key)
+	}
+	if rsaKey.D.Cmp(originalKey.D) != 0 {
+		t.Fatal("decoded ca key does not match original")
+	}
+}
+
+func TestDecodeCertFromSecretMissing(t *testing.T) {
+	secret := &v1.Secret{Data: map[string][]byte{}}
+
+	if _, err := decodeCertFromSecret(secret, "node"); err == nil {
+		t.Fatal("expected error for missing node.pem, got nil")
+	}
+}
+
+func TestWorkloadRolloutComplete(t *testing.T) {
+	tests := []struct {
+		name                                     string
+		generation, observedGeneration           int64
+		replicas, updatedReplicas, readyReplicas int32
+		want                                     bool
+	}{
+		{"fully rolled out", 2, 2, 3, 3, 3, true},
+		{"controller hasn't observed latest generation", 2, 1, 3, 3, 3, false},
+		{"still updating replicas", 2, 2, 3, 2, 3, false},
+		{"updated but not yet ready", 2, 2, 3, 3, 2, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := workloadRolloutComplete(tt.generation, tt.observedGeneration, tt.replicas, tt.updatedReplicas, tt.readyReplicas)
+			if got != tt.want {
+				t.Fatalf("workloadRolloutComplete() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
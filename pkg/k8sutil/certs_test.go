@@ -0,0 +1,156 @@
+package k8sutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"net"
+	"testing"
+
+	esv1 "github.com/sysbart/elasticsearch-operator/pkg/apis/elasticsearchoperator/v1"
+)
+
+func TestGenerateCAAndLeaf(t *testing.T) {
+	caSpec, leafSpecs := buildCertSpecs("default", "test-cluster", esv1.CertsSpec{}, esv1.TLSSpec{})
+
+	ca, err := generateCA(caSpec)
+	if err != nil {
+		t.Fatalf("generateCA() error = %v", err)
+	}
+	if !ca.cert.IsCA {
+		t.Fatal("generated ca certificate is not marked as a CA")
+	}
+
+	for _, spec := range leafSpecs {
+		leaf, err := generateLeaf(spec, ca.cert, ca.key)
+		if err != nil {
+			t.Fatalf("generateLeaf(%s) error = %v", spec.name, err)
+		}
+		if err := leaf.cert.CheckSignatureFrom(ca.cert); err != nil {
+			t.Fatalf("leaf cert %s not signed by ca: %v", spec.name, err)
+		}
+		if leaf.cert.Subject.CommonName != spec.commonName {
+			t.Fatalf("leaf cert %s CommonName = %q, want %q", spec.name, leaf.cert.Subject.CommonName, spec.commonName)
+		}
+	}
+}
+
+func TestGenerateLeafECDSAKeyUsesConfiguredCurve(t *testing.T) {
+	tls := esv1.TLSSpec{
+		Node: esv1.KeySpec{
+			Algorithm:  esv1.KeyAlgorithmECDSA,
+			ECDSACurve: esv1.ECDSACurveP384,
+		},
+	}
+	caSpec, leafSpecs := buildCertSpecs("default", "test-cluster", esv1.CertsSpec{}, tls)
+
+	ca, err := generateCA(caSpec)
+	if err != nil {
+		t.Fatalf("generateCA() error = %v", err)
+	}
+
+	var nodeSpec certSpec
+	for _, spec := range leafSpecs {
+		if spec.name == "node" {
+			nodeSpec = spec
+		}
+	}
+
+	leaf, err := generateLeaf(nodeSpec, ca.cert, ca.key)
+	if err != nil {
+		t.Fatalf("generateLeaf(node) error = %v", err)
+	}
+
+	ecdsaKey, ok := leaf.key.(*ecdsa.PrivateKey)
+	if !ok {
+		t.Fatalf("node leaf key type = %T, want *ecdsa.PrivateKey", leaf.key)
+	}
+	if ecdsaKey.Curve != elliptic.P384() {
+		t.Fatalf("node leaf key curve = %v, want P384", ecdsaKey.Curve)
+	}
+}
+
+func TestApplyKeySpecSANsAndSubjectOverride(t *testing.T) {
+	tls := esv1.TLSSpec{
+		Node: esv1.KeySpec{
+			ExtraDNSNames:    []string{"es.example.com"},
+			ExtraIPAddresses: []string{"10.0.0.5", "not-an-ip"},
+			Subject: &esv1.SubjectSpec{
+				Organization: "acme-corp",
+				CommonName:   "es.example.com",
+			},
+		},
+	}
+	caSpec, leafSpecs := buildCertSpecs("default", "test-cluster", esv1.CertsSpec{}, tls)
+
+	ca, err := generateCA(caSpec)
+	if err != nil {
+		t.Fatalf("generateCA() error = %v", err)
+	}
+
+	var nodeSpec certSpec
+	for _, spec := range leafSpecs {
+		if spec.name == "node" {
+			nodeSpec = spec
+		}
+	}
+
+	leaf, err := generateLeaf(nodeSpec, ca.cert, ca.key)
+	if err != nil {
+		t.Fatalf("generateLeaf(node) error = %v", err)
+	}
+
+	var foundHost bool
+	for _, host := range leaf.cert.DNSNames {
+		if host == "es.example.com" {
+			foundHost = true
+		}
+	}
+	if !foundHost {
+		t.Fatalf("leaf cert DNSNames = %v, want to include %q", leaf.cert.DNSNames, "es.example.com")
+	}
+
+	wantIP := net.ParseIP("10.0.0.5")
+	var foundIP bool
+	for _, ip := range leaf.cert.IPAddresses {
+		if ip.Equal(wantIP) {
+			foundIP = true
+		}
+	}
+	if !foundIP {
+		t.Fatalf("leaf cert IPAddresses = %v, want to include %v", leaf.cert.IPAddresses, wantIP)
+	}
+	if len(leaf.cert.IPAddresses) != 1 {
+		t.Fatalf("leaf cert IPAddresses = %v, want the invalid SAN dropped", leaf.cert.IPAddresses)
+	}
+
+	if leaf.cert.Subject.Organization[0] != "acme-corp" {
+		t.Fatalf("leaf cert Subject.Organization = %v, want %q", leaf.cert.Subject.Organization, "acme-corp")
+	}
+	if leaf.cert.Subject.CommonName != "es.example.com" {
+		t.Fatalf("leaf cert Subject.CommonName = %q, want %q", leaf.cert.Subject.CommonName, "es.example.com")
+	}
+}
+
+func TestToPKCS12AndJKS(t *testing.T) {
+	caSpec, leafSpecs := buildCertSpecs("default", "test-cluster", esv1.CertsSpec{}, esv1.TLSSpec{})
+
+	ca, err := generateCA(caSpec)
+	if err != nil {
+		t.Fatalf("generateCA() error = %v", err)
+	}
+
+	leaf, err := generateLeaf(leafSpecs[0], ca.cert, ca.key)
+	if err != nil {
+		t.Fatalf("generateLeaf() error = %v", err)
+	}
+
+	if _, err := toPKCS12(leaf, ca.cert); err != nil {
+		t.Fatalf("toPKCS12() error = %v", err)
+	}
+	if _, err := toPrivateKeyStore(leaf, ca.cert, "elasticsearch-node"); err != nil {
+		t.Fatalf("toPrivateKeyStore() error = %v", err)
+	}
+	if _, err := toTrustStore(ca.cert, "root-ca"); err != nil {
+		t.Fatalf("toTrustStore() error = %v", err)
+	}
+}